@@ -0,0 +1,183 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Replacement is a single literal substitution applied to a response
+// body.
+type Replacement struct {
+	From, To string
+}
+
+// RewriteRule applies Replacements to responses whose Content-Type
+// (ignoring any "; charset=..." parameter) matches ContentType, a
+// path.Match glob such as "text/*" or "application/json".
+type RewriteRule struct {
+	ContentType  string
+	Replacements []Replacement
+}
+
+// BodyRewriter is a ReverseProxy.ModifyResponse hook that rewrites
+// response bodies matching one of Rules, streaming the rewrite rather
+// than buffering the whole body in memory.
+type BodyRewriter struct {
+	Rules []RewriteRule
+}
+
+// NewBodyRewriter returns a BodyRewriter for the given rules.
+func NewBodyRewriter(rules ...RewriteRule) *BodyRewriter {
+	return &BodyRewriter{Rules: rules}
+}
+
+func (b *BodyRewriter) match(contentType string) (RewriteRule, bool) {
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	for _, rule := range b.Rules {
+		if ok, _ := path.Match(rule.ContentType, contentType); ok {
+			return rule, true
+		}
+	}
+	return RewriteRule{}, false
+}
+
+// ModifyResponse implements the ReverseProxy.ModifyResponse hook.
+func (b *BodyRewriter) ModifyResponse(res *http.Response) error {
+	if res.Body == nil {
+		return nil
+	}
+	rule, ok := b.match(res.Header.Get("Content-Type"))
+	if !ok {
+		return nil
+	}
+	res.Body = newRewritingReadCloser(res.Body, rule.Replacements)
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	return nil
+}
+
+// rewritingReadCloser streams src through bufio, applying replacements
+// to each chunk while holding back just enough unprocessed bytes at
+// the end of the chunk to catch matches that straddle a read boundary.
+type rewritingReadCloser struct {
+	closer io.Closer
+	src    *bufio.Reader
+	repls  []Replacement
+
+	buf []byte // processed bytes ready to be returned
+	tl  []byte // unprocessed bytes held back from the previous read
+	eof bool
+}
+
+func newRewritingReadCloser(rc io.ReadCloser, repls []Replacement) *rewritingReadCloser {
+	return &rewritingReadCloser{closer: rc, src: bufio.NewReader(rc), repls: repls}
+}
+
+func (r *rewritingReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+func (r *rewritingReadCloser) apply(data []byte) []byte {
+	for _, rep := range r.repls {
+		if rep.From == "" {
+			continue
+		}
+		data = bytes.Replace(data, []byte(rep.From), []byte(rep.To), -1)
+	}
+	return data
+}
+
+// overlap returns the length of the longest suffix of data that is a
+// proper, non-empty prefix of some replacement's From pattern - i.e.
+// the longest trailing run that a following read could still extend
+// into a match. A fixed holdback of len(From)-1 isn't enough: a
+// complete match can legitimately end anywhere in data, including its
+// very last bytes, so the only bytes unsafe to rewrite and emit now
+// are the ones that might still be the start of an incomplete match.
+func (r *rewritingReadCloser) overlap(data []byte) int {
+	best := 0
+	for _, rep := range r.repls {
+		from := rep.From
+		if from == "" || len(from) <= 1 {
+			continue
+		}
+		max := len(from) - 1
+		if max > len(data) {
+			max = len(data)
+		}
+		for l := max; l > best; l-- {
+			if bytes.HasSuffix(data, []byte(from[:l])) {
+				best = l
+				break
+			}
+		}
+	}
+	return best
+}
+
+// rewriteChunkSize bounds how much unrewritten data fill accumulates
+// before applying replacements and flushing, so large/slow bodies are
+// still streamed rather than buffered whole.
+const rewriteChunkSize = 32 * 1024
+
+// fill reads and rewrites the next chunk, appending the safely
+// rewritable part to r.buf. It issues a single underlying Read (capped
+// at rewriteChunkSize) and returns as soon as that Read comes back, so
+// a streaming response (e.g. text/event-stream) is flushed to the
+// caller event-by-event rather than being held until rewriteChunkSize
+// bytes accumulate or the backend closes the connection.
+func (r *rewritingReadCloser) fill() error {
+	data := r.tl
+	r.tl = nil
+
+	chunk := make([]byte, rewriteChunkSize)
+	n, err := r.src.Read(chunk)
+	if n > 0 {
+		data = append(data, chunk[:n]...)
+	}
+
+	// Only hold back a tail once we know more data may still follow;
+	// once this Read returned an error (typically io.EOF), there is
+	// nothing left that could complete a straddling match.
+	hold := 0
+	if err == nil {
+		hold = r.overlap(data)
+	}
+	safe, rest := data[:len(data)-hold], data[len(data)-hold:]
+	r.tl = append([]byte(nil), rest...)
+	r.buf = append(r.buf, r.apply(safe)...)
+
+	if err != nil {
+		if err == io.EOF {
+			r.eof = true
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *rewritingReadCloser) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewRewritesPlainTextBody guards against regressing New's
+// backward-compatible text/plain DomainProxy->Domain body rewrite when
+// ModifyResponse was generalized into a pluggable hook.
+func TestNewRewritesPlainTextBody(t *testing.T) {
+	var domainProxy string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("hello from " + domainProxy + ", visit http://" + domainProxy + "/path"))
+	}))
+	defer backend.Close()
+	domainProxy = strings.TrimPrefix(backend.URL, "http://")
+
+	proxy, err := New(domainProxy, "public.example.com")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "http://public.example.com/", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, domainProxy) {
+		t.Errorf("expected %s to be rewritten to public.example.com, got body %q", domainProxy, body)
+	}
+	if !strings.Contains(body, "public.example.com") {
+		t.Errorf("expected rewritten body to mention public.example.com, got %q", body)
+	}
+}
+
+// TestRewritingReadCloserFlushesEachReadImmediately guards against
+// fill() re-buffering up to rewriteChunkSize before releasing any
+// bytes, which defeated chunk0-3's streaming-flush guarantee: a
+// backend trickling small writes through a BodyRewriter rule would
+// never see a single Read return until 32KB accumulated or EOF.
+func TestRewritingReadCloserFlushesEachReadImmediately(t *testing.T) {
+	pr, pw := io.Pipe()
+	rc := newRewritingReadCloser(pr, []Replacement{{From: "foo", To: "bar"}})
+
+	go func() {
+		pw.Write([]byte("hello "))
+	}()
+
+	buf := make([]byte, 64)
+	done := make(chan int, 1)
+	go func() {
+		n, _ := rc.Read(buf)
+		done <- n
+	}()
+
+	select {
+	case n := <-done:
+		if got := string(buf[:n]); got != "hello " {
+			t.Errorf("Read = %q, want %q", got, "hello ")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read blocked waiting for rewriteChunkSize bytes instead of returning the available write")
+	}
+	pw.Close()
+}
+
+// TestRewritingReadCloserHandlesStraddlingMatch checks that a
+// replacement whose From straddles two separate underlying Reads is
+// still rewritten, since fill() holds back the trailing bytes that are
+// a proper prefix of From ("fo" here) rather than emitting them.
+func TestRewritingReadCloserHandlesStraddlingMatch(t *testing.T) {
+	pr, pw := io.Pipe()
+	rc := newRewritingReadCloser(pr, []Replacement{{From: "foobar", To: "baz"}})
+
+	go func() {
+		pw.Write([]byte("see fo"))
+		pw.Write([]byte("obar now"))
+		pw.Close()
+	}()
+
+	out, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if got, want := string(out), "see baz now"; got != want {
+		t.Errorf("rewritten body = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isTrustedProxy reports whether ip is listed in p.TrustedProxies.
+func (p *ReverseProxy) isTrustedProxy(ip string) bool {
+	for _, trusted := range p.TrustedProxies {
+		if trusted == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwardedHeaders appends the client's address to X-Forwarded-For
+// (rather than overwriting it, so the full proxy chain is preserved),
+// and sets X-Forwarded-Host and X-Forwarded-Proto for the backend.
+//
+// If StripClientForwarded is set and req did not come from a trusted
+// proxy, any X-Forwarded-For value the client supplied is discarded
+// before appending, so clients cannot spoof the chain. As with the
+// standard library's reverse proxy, a Director may opt an individual
+// request out of X-Forwarded-For entirely by setting
+// outreq.Header["X-Forwarded-For"] to a nil (not empty) slice; that
+// opt-out takes precedence over StripClientForwarded, since there is
+// nothing left to strip or append to once a Director has asked to be
+// left alone.
+func (p *ReverseProxy) setForwardedHeaders(outreq, req *http.Request) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	prior, ok := outreq.Header["X-Forwarded-For"]
+	omit := ok && prior == nil // Director asked us not to set the header.
+
+	if !omit && p.StripClientForwarded && !p.isTrustedProxy(clientIP) {
+		prior = nil
+		outreq.Header.Del("X-Forwarded-For")
+	}
+
+	if clientIP != "" {
+		if len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		if !omit {
+			outreq.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+
+	if outreq.Header.Get("X-Forwarded-Host") == "" {
+		outreq.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	outreq.Header.Set("X-Forwarded-Proto", proto)
+}
@@ -0,0 +1,105 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetForwardedHeadersAppendsToExistingChain(t *testing.T) {
+	p := &ReverseProxy{}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+
+	outreq := req.Clone(req.Context())
+	outreq.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	p.setForwardedHeaders(outreq, req)
+
+	if got, want := outreq.Header.Get("X-Forwarded-For"), "10.0.0.1, 203.0.113.9"; got != want {
+		t.Errorf("X-Forwarded-For = %q, want %q", got, want)
+	}
+}
+
+func TestSetForwardedHeadersDirectorOptOut(t *testing.T) {
+	p := &ReverseProxy{}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+
+	outreq := req.Clone(req.Context())
+	outreq.Header["X-Forwarded-For"] = nil // Director opt-out.
+
+	p.setForwardedHeaders(outreq, req)
+
+	if got := outreq.Header.Get("X-Forwarded-For"); got != "" {
+		t.Errorf("expected X-Forwarded-For to remain unset after Director opt-out, got %q", got)
+	}
+}
+
+func TestSetForwardedHeadersStripClientForwardedFromUntrusted(t *testing.T) {
+	p := &ReverseProxy{StripClientForwarded: true, TrustedProxies: []string{"10.0.0.1"}}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.9:1234" // not in TrustedProxies
+
+	outreq := req.Clone(req.Context())
+	outreq.Header.Set("X-Forwarded-For", "1.2.3.4") // spoofed by the client
+
+	p.setForwardedHeaders(outreq, req)
+
+	if got, want := outreq.Header.Get("X-Forwarded-For"), "203.0.113.9"; got != want {
+		t.Errorf("X-Forwarded-For = %q, want %q (spoofed prior value should be stripped)", got, want)
+	}
+}
+
+func TestSetForwardedHeadersStripClientForwardedFromTrusted(t *testing.T) {
+	p := &ReverseProxy{StripClientForwarded: true, TrustedProxies: []string{"10.0.0.1"}}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "10.0.0.1:1234" // trusted
+
+	outreq := req.Clone(req.Context())
+	outreq.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+	p.setForwardedHeaders(outreq, req)
+
+	if got, want := outreq.Header.Get("X-Forwarded-For"), "1.2.3.4, 10.0.0.1"; got != want {
+		t.Errorf("X-Forwarded-For = %q, want %q (trusted proxy's chain should be kept)", got, want)
+	}
+}
+
+// TestSetForwardedHeadersStripClientForwardedHonorsDirectorOptOut
+// guards against StripClientForwarded silently overriding a Director's
+// nil-slice opt-out by deleting the header before the opt-out is
+// observed.
+func TestSetForwardedHeadersStripClientForwardedHonorsDirectorOptOut(t *testing.T) {
+	p := &ReverseProxy{StripClientForwarded: true}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.9:1234" // untrusted, would normally be stripped
+
+	outreq := req.Clone(req.Context())
+	outreq.Header["X-Forwarded-For"] = nil // Director opt-out.
+
+	p.setForwardedHeaders(outreq, req)
+
+	if v, ok := outreq.Header["X-Forwarded-For"]; ok && v != nil {
+		t.Errorf("expected Director opt-out to survive StripClientForwarded, got %q", outreq.Header.Get("X-Forwarded-For"))
+	}
+}
+
+func TestSetForwardedHeadersSetsHostAndProto(t *testing.T) {
+	p := &ReverseProxy{}
+	req := httptest.NewRequest("GET", "http://example.com/path", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+
+	outreq := req.Clone(req.Context())
+	p.setForwardedHeaders(outreq, req)
+
+	if got := outreq.Header.Get("X-Forwarded-Host"); got != "example.com" {
+		t.Errorf("X-Forwarded-Host = %q, want %q", got, "example.com")
+	}
+	if got := outreq.Header.Get("X-Forwarded-Proto"); got != "http" {
+		t.Errorf("X-Forwarded-Proto = %q, want %q", got, "http")
+	}
+}
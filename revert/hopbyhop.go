@@ -0,0 +1,41 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopByHopHeaders are removed on both the outbound request and the
+// inbound response, per RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stripHopByHopHeaders removes the fixed hop-by-hop headers from h, as
+// well as any additional headers named in h's Connection header (e.g.
+// "Connection: X-Forwarded-For" cannot be used to smuggle that header
+// past the proxy).
+func stripHopByHopHeaders(h http.Header) {
+	for _, f := range h["Connection"] {
+		for _, sf := range strings.Split(f, ",") {
+			if name := textproto.TrimString(sf); name != "" {
+				h.Del(textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(textproto.CanonicalMIMEHeaderKey(name))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStripHopByHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "close")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Proxy-Authenticate", "Basic")
+	h.Set("Proxy-Authorization", "Basic abc")
+	h.Set("Te", "trailers")
+	h.Set("Trailer", "X-Foo")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Upgrade", "websocket")
+	h.Set("Content-Type", "text/plain")
+
+	stripHopByHopHeaders(h)
+
+	for _, name := range hopByHopHeaders {
+		if v := h.Get(name); v != "" {
+			t.Errorf("expected %s to be stripped, got %q", name, v)
+		}
+	}
+	if got := h.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type should survive stripping, got %q", got)
+	}
+}
+
+// TestStripHopByHopHeadersConnectionSmuggling verifies that a client
+// cannot use Connection to smuggle an arbitrary header name (here
+// X-Forwarded-For) past the proxy's own stripping.
+func TestStripHopByHopHeadersConnectionSmuggling(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "X-Forwarded-For")
+	h.Set("X-Forwarded-For", "10.0.0.1")
+	h.Set("X-Real-IP", "10.0.0.1")
+
+	stripHopByHopHeaders(h)
+
+	if v := h.Get("X-Forwarded-For"); v != "" {
+		t.Errorf("expected X-Forwarded-For named by Connection to be stripped, got %q", v)
+	}
+	if got := h.Get("X-Real-IP"); got != "10.0.0.1" {
+		t.Errorf("unrelated header X-Real-IP should survive stripping, got %q", got)
+	}
+}
+
+func TestStripHopByHopHeadersConnectionSmugglingCaseInsensitive(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "x-forwarded-for, keep-alive")
+	h.Set("X-Forwarded-For", "10.0.0.1")
+
+	stripHopByHopHeaders(h)
+
+	if v := h.Get("X-Forwarded-For"); v != "" {
+		t.Errorf("expected case-insensitive header named by Connection to be stripped, got %q", v)
+	}
+	if v := h.Get("Keep-Alive"); v != "" {
+		t.Errorf("expected Keep-Alive to be stripped, got %q", v)
+	}
+}
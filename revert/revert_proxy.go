@@ -7,7 +7,7 @@
 package revert
 
 import (
-	"bytes"
+	"context"
 	"io"
 	"log"
 	"net"
@@ -39,8 +39,34 @@ type ReverseProxy struct {
 	// If zero, no periodic flushing is done.
 	FlushInterval int64
 
+	// ModifyResponse is an optional function that modifies the
+	// Response from the backend. If it returns an error, the
+	// proxy returns a StatusBadGateway error.
+	ModifyResponse func(*http.Response) error
+
+	// DialContext, if non-nil, is used to dial the backend when
+	// tunneling WebSocket upgrades and CONNECT requests. If nil,
+	// (&net.Dialer{}).DialContext is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
 	DomainProxy, Domain string
 	forbiddens          []string
+
+	// TrustedProxies lists the IPs (as returned by net.SplitHostPort
+	// on RemoteAddr) allowed to supply their own X-Forwarded-For
+	// header. It is only consulted when StripClientForwarded is true.
+	TrustedProxies []string
+
+	// StripClientForwarded, when true, discards any pre-existing
+	// X-Forwarded-For header on requests whose RemoteAddr is not in
+	// TrustedProxies before appending the client IP, preventing
+	// clients from spoofing the chain.
+	StripClientForwarded bool
+
+	// mu guards routes, the vhost routing table populated by
+	// Register/UnRegister.
+	mu     sync.RWMutex
+	routes map[string][]*route
 }
 
 func singleJoiningSlash(a, b string) string {
@@ -74,6 +100,11 @@ func newSingleHostReverseProxy(target *url.URL) *ReverseProxy {
 	return &ReverseProxy{Director: director}
 }
 
+// New returns a single-host ReverseProxy to domainProxy. It rewrites
+// domainProxy to domain in text/plain response bodies of any charset
+// and status code (as well as in Location and Set-Cookie headers, handled
+// separately by rewriteDomainHeaders); pass a custom ModifyResponse to
+// rewrite other content types too.
 func New(domainProxy, domain string) (proxy *ReverseProxy, err error) {
 	u, err := url.Parse("http://" + domainProxy)
 	if err != nil {
@@ -81,6 +112,10 @@ func New(domainProxy, domain string) (proxy *ReverseProxy, err error) {
 	}
 	proxy = newSingleHostReverseProxy(u)
 	proxy.DomainProxy, proxy.Domain = domainProxy, domain
+	proxy.ModifyResponse = NewBodyRewriter(RewriteRule{
+		ContentType:  "text/plain",
+		Replacements: []Replacement{{From: domainProxy, To: domain}},
+	}).ModifyResponse
 	return
 }
 
@@ -102,31 +137,67 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		transport = http.DefaultTransport
 	}
 
+	director := p.Director
+	domainProxy := p.DomainProxy
+	rewriteFrom, rewriteTo := p.DomainProxy, p.Domain
+	rewriteHost := true
+
+	if p.hasRoutes() {
+		// CONNECT requests have no URL path (req.URL.Path == ""), which
+		// would only ever match a route registered with location ""; a
+		// CONNECT is routed as if it targeted the root location "/"
+		// instead so it can reach any route registered for the domain.
+		path := req.URL.Path
+		if path == "" {
+			path = "/"
+		}
+		r, ok := p.lookup(req.Host, path)
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		domainProxy = r.backend
+		rewriteHost = r.rewriteHost
+		rewriteFrom, rewriteTo = r.backend, r.domain
+		director = func(outreq *http.Request) {
+			outreq.URL.Scheme = "http"
+			outreq.URL.Host = r.backend
+		}
+	}
+
+	if isTunnelRequest(req) {
+		p.serveTunnel(rw, req, domainProxy, rewriteHost)
+		return
+	}
+
 	outreq := new(http.Request)
 	*outreq = *req // includes shallow copies of maps, but okay
 
-	p.Director(outreq)
+	// Clone the header map before Director runs so that Director's
+	// mutations (including the X-Forwarded-For nil-slice opt-out
+	// documented in setForwardedHeaders) land on outreq's own map
+	// rather than the one shared with req.
+	outreq.Header = make(http.Header)
+	copyHeader(outreq.Header, req.Header)
+
+	director(outreq)
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
 	outreq.ProtoMinor = 1
 	outreq.Close = false
 
-	// Remove the connection header to the backend.  We want a
-	// persistent connection, regardless of what the client sent
-	// to us.  This is modifying the same underlying map from req
-	// (shallow copied above) so we only copy it if necessary.
-	if outreq.Header.Get("Connection") != "" {
-		outreq.Header = make(http.Header)
-		copyHeader(outreq.Header, req.Header)
-		outreq.Header.Del("Connection")
-	}
+	// Strip hop-by-hop headers to the backend. We want a persistent
+	// connection, regardless of what the client sent to us. Doing
+	// this after Director has run preserves whatever Director did to
+	// outreq.Header.
+	stripHopByHopHeaders(outreq.Header)
 
-	if clientIp, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-		outreq.Header.Set("X-Forwarded-For", clientIp)
-	}
+	p.setForwardedHeaders(outreq, req)
 
-	outreq.URL.Host = p.DomainProxy
-	outreq.Host = outreq.URL.Host
+	outreq.URL.Host = domainProxy
+	if rewriteHost {
+		outreq.Host = outreq.URL.Host
+	}
 	// spew.Dump(outreq)
 	dmp, _ := httputil.DumpRequest(outreq, false)
 	println("request 。。。。")
@@ -139,12 +210,10 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	copyHeader(rw.Header(), res.Header)
-
-	dmp2, _ := httputil.DumpResponse(res, false)
-	println("response。。。。")
-	log.Println(string(dmp2))
-
+	// Check Forbidden() prefixes against the backend's own Location
+	// before rewriteDomainHeaders rewrites it to the public domain;
+	// otherwise a prefix configured against the backend's domain can
+	// never match once the header already reads the public one.
 	switch res.StatusCode {
 	case 301, 302, 307:
 		l := res.Header["Location"]
@@ -160,23 +229,33 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	rewriteDomainHeaders(res, rewriteFrom, rewriteTo)
+
+	if p.ModifyResponse != nil {
+		if err := p.ModifyResponse(res); err != nil {
+			log.Printf("http: proxy ModifyResponse error: %v", err)
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+
+	stripHopByHopHeaders(res.Header)
+	copyHeader(rw.Header(), res.Header)
+
+	dmp2, _ := httputil.DumpResponse(res, false)
+	println("response。。。。")
+	log.Println(string(dmp2))
+
 	rw.WriteHeader(res.StatusCode)
 	if res.Body != nil {
 		var dst io.Writer = rw
-		if p.FlushInterval != 0 {
-			if wf, ok := rw.(writeFlusher); ok {
-				dst = &maxLatencyWriter{dst: wf, latency: p.FlushInterval}
-			}
-		}
-		if res.StatusCode == 200 {
-			ct := res.Header["Content-Type"]
-			if ct != nil && ct[0] == "text/plain; charset=utf-8" {
-				log.Println("Transform: text/plain; charset=utf-8")
-				buf := bytes.NewBuffer(nil)
-				io.Copy(buf, res.Body)
-				b := bytes.Replace(buf.Bytes(), []byte(p.DomainProxy), []byte(p.Domain), -1)
-				io.Copy(dst, bytes.NewBuffer(b))
-				return
+		if wf, ok := rw.(writeFlusher); ok {
+			streaming := isStreamingResponse(res)
+			if p.FlushInterval != 0 || streaming {
+				flushImmediately := streaming || p.FlushInterval < 0
+				mlw := newMaxLatencyWriter(wf, time.Duration(p.FlushInterval), flushImmediately)
+				defer mlw.stop()
+				dst = mlw
 			}
 		}
 		io.Copy(dst, res.Body)
@@ -188,40 +267,63 @@ type writeFlusher interface {
 	http.Flusher
 }
 
+// maxLatencyWriter flushes dst periodically, or after every Write when
+// flushImmediately is set (used for streaming responses and negative
+// FlushInterval values). Shutdown is coordinated through a context
+// rather than a done channel so that a write error racing with an
+// already-stopped flushLoop can never block.
 type maxLatencyWriter struct {
-	dst     writeFlusher
-	latency int64 // nanos
+	dst              writeFlusher
+	latency          time.Duration
+	flushImmediately bool
 
-	lk   sync.Mutex // protects init of done, as well Write + Flush
-	done chan bool
+	mu       sync.Mutex // protects Write + Flush
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
 }
 
-func (m *maxLatencyWriter) Write(p []byte) (n int, err error) {
-	m.lk.Lock()
-	defer m.lk.Unlock()
-	if m.done == nil {
-		m.done = make(chan bool)
+func newMaxLatencyWriter(dst writeFlusher, latency time.Duration, flushImmediately bool) *maxLatencyWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &maxLatencyWriter{dst: dst, latency: latency, flushImmediately: flushImmediately, ctx: ctx, cancel: cancel}
+	if !flushImmediately && latency > 0 {
 		go m.flushLoop()
 	}
+	return m
+}
+
+func (m *maxLatencyWriter) Write(p []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	n, err = m.dst.Write(p)
 	if err != nil {
-		m.done <- true
+		m.stop()
+		return
+	}
+	if m.flushImmediately {
+		m.dst.Flush()
 	}
 	return
 }
 
+// stop ends flushLoop, if any. It is safe to call more than once and
+// to race with a Write that is also stopping on error.
+func (m *maxLatencyWriter) stop() {
+	m.stopOnce.Do(m.cancel)
+}
+
 func (m *maxLatencyWriter) flushLoop() {
-	t := time.NewTicker(time.Duration(m.latency))
+	t := time.NewTimer(m.latency)
 	defer t.Stop()
 	for {
 		select {
 		case <-t.C:
-			m.lk.Lock()
+			m.mu.Lock()
 			m.dst.Flush()
-			m.lk.Unlock()
-		case <-m.done:
+			m.mu.Unlock()
+			t.Reset(m.latency)
+		case <-m.ctx.Done():
 			return
 		}
 	}
-	panic("unreached")
 }
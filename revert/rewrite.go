@@ -0,0 +1,32 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rewriteDomainHeaders replaces occurrences of from with to in the
+// response's Location header (redirects) and in the Domain= attribute
+// of any Set-Cookie headers, so that backend-relative references keep
+// working behind the proxy's public domain.
+func rewriteDomainHeaders(res *http.Response, from, to string) {
+	if from == "" || from == to {
+		return
+	}
+
+	if locs, ok := res.Header["Location"]; ok {
+		for i, loc := range locs {
+			locs[i] = strings.Replace(loc, from, to, -1)
+		}
+	}
+
+	if cookies, ok := res.Header["Set-Cookie"]; ok {
+		for i, cookie := range cookies {
+			cookies[i] = strings.Replace(cookie, "Domain="+from, "Domain="+to, -1)
+		}
+	}
+}
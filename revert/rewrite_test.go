@@ -0,0 +1,41 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestForbiddenChecksLocationBeforeRewrite guards against
+// rewriteDomainHeaders running before the Forbidden() prefix check:
+// a prefix configured against the backend's own domain must still
+// block the redirect even though the Location header is later
+// rewritten to the public domain.
+func TestForbiddenChecksLocationBeforeRewrite(t *testing.T) {
+	var domainProxy string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://"+domainProxy+"/admin")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer backend.Close()
+	domainProxy = strings.TrimPrefix(backend.URL, "http://")
+
+	proxy, err := New(domainProxy, "public.example.com")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	proxy.Forbidden("http://" + domainProxy + "/admin")
+
+	req := httptest.NewRequest("GET", "http://public.example.com/", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d (redirect to forbidden backend path should be blocked)", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isStreamingResponse reports whether res looks like a streaming
+// response (SSE, chunked with no declared length, or otherwise of
+// unknown length), in which case it should be flushed to the client
+// after every write rather than waiting for FlushInterval.
+func isStreamingResponse(res *http.Response) bool {
+	if res.ContentLength == -1 {
+		return true
+	}
+	if ct := res.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeFlusher struct {
+	mu       sync.Mutex
+	flushes  int
+	writeErr error
+}
+
+func (f *fakeFlusher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+	return len(p), nil
+}
+
+func (f *fakeFlusher) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushes++
+}
+
+func (f *fakeFlusher) flushCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushes
+}
+
+func TestMaxLatencyWriterFlushesImmediatelyWhenConfigured(t *testing.T) {
+	f := &fakeFlusher{}
+	w := newMaxLatencyWriter(f, 0, true)
+	defer w.stop()
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("x")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if got := f.flushCount(); got != 3 {
+		t.Errorf("flushes = %d, want 3", got)
+	}
+}
+
+func TestMaxLatencyWriterPeriodicFlush(t *testing.T) {
+	f := &fakeFlusher{}
+	w := newMaxLatencyWriter(f, 10*time.Millisecond, false)
+	defer w.stop()
+
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if got := f.flushCount(); got == 0 {
+		t.Error("expected at least one periodic flush after several latency periods elapsed")
+	}
+}
+
+// TestMaxLatencyWriterStopDoesNotBlockOnWriteError guards against the
+// fixed shutdown race: a Write error arriving after flushLoop has
+// already exited (e.g. the request was already stopped) must not
+// block trying to signal a goroutine that is no longer listening.
+func TestMaxLatencyWriterStopDoesNotBlockOnWriteError(t *testing.T) {
+	f := &fakeFlusher{}
+	w := newMaxLatencyWriter(f, time.Millisecond, false)
+
+	w.stop()
+	time.Sleep(5 * time.Millisecond) // let flushLoop actually return
+
+	f.mu.Lock()
+	f.writeErr = errors.New("boom")
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.Write([]byte("x"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked signaling an already-stopped flushLoop")
+	}
+}
+
+func TestMaxLatencyWriterStopIsIdempotent(t *testing.T) {
+	f := &fakeFlusher{}
+	w := newMaxLatencyWriter(f, time.Millisecond, false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	cases := []struct {
+		name string
+		res  *http.Response
+		want bool
+	}{
+		{"unknown length", &http.Response{ContentLength: -1, Header: http.Header{}}, true},
+		{"event-stream", &http.Response{ContentLength: 0, Header: http.Header{"Content-Type": {"text/event-stream"}}}, true},
+		{"event-stream with charset", &http.Response{ContentLength: 0, Header: http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}}, true},
+		{"plain json", &http.Response{ContentLength: 42, Header: http.Header{"Content-Type": {"application/json"}}}, false},
+	}
+	for _, c := range cases {
+		if got := isStreamingResponse(c.res); got != c.want {
+			t.Errorf("%s: isStreamingResponse = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
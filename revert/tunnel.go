@@ -0,0 +1,119 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isTunnelRequest reports whether req should be tunneled directly to
+// the backend rather than proxied through Transport.RoundTrip: an
+// HTTP CONNECT request, or a WebSocket upgrade.
+func isTunnelRequest(req *http.Request) bool {
+	if req.Method == http.MethodConnect {
+		return true
+	}
+	return headerContainsToken(req.Header.Get("Connection"), "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContainsToken reports whether token (case-insensitively)
+// appears in the comma-separated header value v.
+func headerContainsToken(v, token string) bool {
+	for _, s := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ReverseProxy) dialBackend(ctx context.Context, addr string) (net.Conn, error) {
+	dial := p.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return dial(ctx, "tcp", addr)
+}
+
+// serveTunnel handles WebSocket upgrades and HTTP CONNECT requests by
+// hijacking the client connection, dialing backend directly, and
+// splicing the two connections together. rewriteHost controls, for the
+// WebSocket case, whether the request replayed to backend gets its
+// Host header rewritten to backend or keeps the client's original
+// Host, matching the plain-HTTP path's handling of the same flag.
+func (p *ReverseProxy) serveTunnel(rw http.ResponseWriter, req *http.Request, backend string, rewriteHost bool) {
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "proxy: connection does not support hijacking", http.StatusInternalServerError)
+		return
+	}
+
+	backendConn, err := p.dialBackend(req.Context(), backend)
+	if err != nil {
+		log.Printf("http: proxy tunnel dial error: %v", err)
+		http.Error(rw, "proxy: error dialing backend", http.StatusBadGateway)
+		return
+	}
+
+	clientConn, clientBuf, err := hj.Hijack()
+	if err != nil {
+		backendConn.Close()
+		log.Printf("http: proxy tunnel hijack error: %v", err)
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			clientConn.Close()
+			backendConn.Close()
+			return
+		}
+	} else {
+		outreq := new(http.Request)
+		*outreq = *req
+		outreq.URL.Host = backend
+		if rewriteHost {
+			outreq.Host = backend
+		}
+		if err := outreq.Write(backendConn); err != nil {
+			clientConn.Close()
+			backendConn.Close()
+			return
+		}
+	}
+
+	// Any bytes the client already sent past the request headers
+	// (buffered by the hijack) belong to the tunneled stream too.
+	if n := clientBuf.Reader.Buffered(); n > 0 {
+		buffered := make([]byte, n)
+		io.ReadFull(clientBuf, buffered)
+		backendConn.Write(buffered)
+	}
+
+	splice(clientConn, backendConn)
+}
+
+// splice copies bytes in both directions between a and b until either
+// side closes, then closes both.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+}
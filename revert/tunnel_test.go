@@ -0,0 +1,166 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startEchoBackend listens on the loopback interface and echoes back
+// whatever bytes it receives on the first connection it accepts.
+func startEchoBackend(t *testing.T) (addr string, closeFn func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestServeTunnelConnectSplicesBytes(t *testing.T) {
+	backendAddr, closeBackend := startEchoBackend(t)
+	defer closeBackend()
+
+	p := &ReverseProxy{DomainProxy: backendAddr}
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "CONNECT "+backendAddr+" HTTP/1.1\r\nHost: "+backendAddr+"\r\n\r\n"); err != nil {
+		t.Fatalf("write CONNECT: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read CONNECT response: %v", err)
+	}
+	if !strings.Contains(status, "200") {
+		t.Fatalf("CONNECT response = %q, want 200", status)
+	}
+	// Consume the blank line terminating the response headers.
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("read trailing CRLF: %v", err)
+	}
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		t.Fatalf("read echoed payload: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("echoed payload = %q, want %q", buf, "ping")
+	}
+}
+
+// startHostCapturingWSBackend listens for a single WebSocket upgrade
+// request, replies with a 101 response, and reports the Host header it
+// was sent so tests can assert on rewriteHost behavior.
+func startHostCapturingWSBackend(t *testing.T) (addr string, gotHost <-chan string, closeFn func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	hostCh := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		hostCh <- req.Host
+
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.Copy(conn, conn)
+	}()
+	return ln.Addr().String(), hostCh, func() { ln.Close() }
+}
+
+func TestServeTunnelWebSocketRewriteHost(t *testing.T) {
+	cases := []struct {
+		name        string
+		rewriteHost bool
+		wantHost    func(clientHost, backendAddr string) string
+	}{
+		{
+			name:        "rewrites Host to backend",
+			rewriteHost: true,
+			wantHost:    func(clientHost, backendAddr string) string { return backendAddr },
+		},
+		{
+			name:        "preserves client Host",
+			rewriteHost: false,
+			wantHost:    func(clientHost, backendAddr string) string { return clientHost },
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			backendAddr, gotHost, closeBackend := startHostCapturingWSBackend(t)
+			defer closeBackend()
+
+			p := &ReverseProxy{DomainProxy: backendAddr}
+			p.Register("ws.example.com", "/", backendAddr, c.rewriteHost)
+			proxyServer := httptest.NewServer(p)
+			defer proxyServer.Close()
+
+			conn, err := net.Dial("tcp", strings.TrimPrefix(proxyServer.URL, "http://"))
+			if err != nil {
+				t.Fatalf("dial proxy: %v", err)
+			}
+			defer conn.Close()
+
+			clientHost := "ws.example.com"
+			req, err := http.NewRequest("GET", "http://"+proxyServer.Listener.Addr().String()+"/", nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Host = clientHost
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			if err := req.Write(conn); err != nil {
+				t.Fatalf("write upgrade request: %v", err)
+			}
+
+			select {
+			case host := <-gotHost:
+				if want := c.wantHost(clientHost, backendAddr); host != want {
+					t.Errorf("backend saw Host = %q, want %q", host, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("backend never received the replayed upgrade request")
+			}
+		})
+	}
+}
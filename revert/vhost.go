@@ -0,0 +1,124 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+// route describes one registered location under a virtual host.
+type route struct {
+	domain      string
+	location    string
+	backend     string
+	rewriteHost bool
+}
+
+// Register adds (or replaces) a route for the given domain and location
+// prefix, directing matching requests to backend. When rewriteHost is
+// true, the outgoing request's Host header is set to backend; otherwise
+// the original client Host header is preserved.
+//
+// Multiple locations may be registered under the same domain. At
+// dispatch time the location with the longest matching prefix wins,
+// so more specific locations should simply be registered alongside
+// less specific ones; Register keeps them ordered accordingly.
+//
+// domain also doubles as the rewrite target for this route: Location
+// and Set-Cookie Domain= headers coming back from backend are rewritten
+// from backend to domain, the same way the single-host DomainProxy/
+// Domain fields work.
+func (p *ReverseProxy) Register(domain, location, backend string, rewriteHost bool) error {
+	if domain == "" || location == "" || backend == "" {
+		return errors.New("revert: domain, location and backend must not be empty")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.routes == nil {
+		p.routes = make(map[string][]*route)
+	}
+	// Routes are immutable once published: lookup hands out the *route
+	// pointer to callers after releasing p.mu, so replacing an existing
+	// location must allocate a new route rather than mutate the one a
+	// concurrent request may already be reading.
+	r := &route{domain: domain, location: location, backend: backend, rewriteHost: rewriteHost}
+	routes := p.routes[domain]
+	replaced := false
+	for i, old := range routes {
+		if old.location == location {
+			routes[i] = r
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		routes = append(routes, r)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].location) > len(routes[j].location)
+	})
+	p.routes[domain] = routes
+	return nil
+}
+
+// UnRegister removes the route previously added with Register for the
+// given domain and location. It is a no-op if no such route exists.
+func (p *ReverseProxy) UnRegister(domain, location string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	routes := p.routes[domain]
+	for i, r := range routes {
+		if r.location == location {
+			p.routes[domain] = append(routes[:i], routes[i+1:]...)
+			break
+		}
+	}
+	if len(p.routes[domain]) == 0 {
+		delete(p.routes, domain)
+	}
+}
+
+// hasRoutes reports whether any vhost routes have been registered.
+func (p *ReverseProxy) hasRoutes() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.routes) > 0
+}
+
+// lookup returns the best matching route for host and path, i.e. the
+// registered location with the longest prefix match. Note that this is
+// a path-prefix match: it has no way to distinguish destinations for
+// requests that carry no meaningful URL path of their own, such as
+// CONNECT (ServeHTTP maps those to "/" before calling lookup).
+func (p *ReverseProxy) lookup(host, path string) (*route, bool) {
+	host = stripPort(host)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.routes[host] {
+		if strings.HasPrefix(path, r.location) {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// stripPort removes a trailing ":port" from a Host header value, the
+// way net/http does when matching virtual hosts.
+func stripPort(host string) string {
+	if !strings.Contains(host, ":") {
+		return host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,138 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package revert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegisterLongestPrefixMatch(t *testing.T) {
+	p := &ReverseProxy{}
+	p.Register("example.com", "/", "root-backend", false)
+	p.Register("example.com", "/api", "api-backend", false)
+	p.Register("example.com", "/api/v2", "api-v2-backend", false)
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/", "root-backend"},
+		{"/about", "root-backend"},
+		{"/api", "api-backend"},
+		{"/api/v1/thing", "api-backend"},
+		{"/api/v2/thing", "api-v2-backend"},
+	}
+	for _, c := range cases {
+		r, ok := p.lookup("example.com", c.path)
+		if !ok {
+			t.Errorf("lookup(%q) = not found, want %q", c.path, c.want)
+			continue
+		}
+		if r.backend != c.want {
+			t.Errorf("lookup(%q) = %q, want %q", c.path, r.backend, c.want)
+		}
+	}
+}
+
+func TestRegisterReplacesExistingLocation(t *testing.T) {
+	p := &ReverseProxy{}
+	p.Register("example.com", "/api", "v1-backend", false)
+	p.Register("example.com", "/api", "v2-backend", true)
+
+	r, ok := p.lookup("example.com", "/api/thing")
+	if !ok {
+		t.Fatal("lookup did not find route")
+	}
+	if r.backend != "v2-backend" || !r.rewriteHost {
+		t.Errorf("got backend=%q rewriteHost=%v, want backend=v2-backend rewriteHost=true", r.backend, r.rewriteHost)
+	}
+	if got := len(p.routes["example.com"]); got != 1 {
+		t.Errorf("expected Register to replace rather than duplicate, got %d routes", got)
+	}
+}
+
+func TestUnRegister(t *testing.T) {
+	p := &ReverseProxy{}
+	p.Register("example.com", "/api", "api-backend", false)
+	p.UnRegister("example.com", "/api")
+
+	if _, ok := p.lookup("example.com", "/api"); ok {
+		t.Error("expected lookup to fail after UnRegister")
+	}
+	if _, ok := p.routes["example.com"]; ok {
+		t.Error("expected the domain entry to be removed once its last route is gone")
+	}
+}
+
+func TestServeHTTPVhostDispatch(t *testing.T) {
+	apiBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api"))
+	}))
+	defer apiBackend.Close()
+	rootBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	}))
+	defer rootBackend.Close()
+
+	p := &ReverseProxy{}
+	p.Register("example.com", "/", strings.TrimPrefix(rootBackend.URL, "http://"), false)
+	p.Register("example.com", "/api", strings.TrimPrefix(apiBackend.URL, "http://"), false)
+
+	get := func(host, path string) (int, string) {
+		req := httptest.NewRequest("GET", "http://"+host+path, nil)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := get("example.com", "/api/widgets"); code != 200 || body != "api" {
+		t.Errorf("GET /api/widgets = %d %q, want 200 \"api\"", code, body)
+	}
+	if code, body := get("example.com", "/other"); code != 200 || body != "root" {
+		t.Errorf("GET /other = %d %q, want 200 \"root\"", code, body)
+	}
+	if code, _ := get("unknown.example.net", "/"); code != http.StatusNotFound {
+		t.Errorf("GET unknown host = %d, want 404", code)
+	}
+}
+
+// TestRegisterLookupRace exercises Register racing with ServeHTTP's
+// lookup under the race detector: a concurrent Register replacing a
+// location must never hand a half-written *route to an in-flight
+// lookup (the bug fixed by allocating a new route on replace instead
+// of mutating the published one in place).
+func TestRegisterLookupRace(t *testing.T) {
+	p := &ReverseProxy{}
+	p.Register("example.com", "/", "backend-0", false)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p.Register("example.com", "/", "backend-1", i%2 == 0)
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		if r, ok := p.lookup("example.com", "/"); ok {
+			_ = r.backend
+			_ = r.rewriteHost
+		}
+	}
+	close(stop)
+	wg.Wait()
+}